@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Zamiell/hanabi-live/src/models"
+	"github.com/nats-io/nats.go"
+)
+
+// GameSession is the subset of *Session that a Game interacts with in order
+// to run a game. It exists so that Bot can stand in for a real websocket
+// session: every "p.Session.Emit(...)" / "p.Session.NotifyGameAction(...)"
+// call site in game.go and gameEnd.go keeps working unchanged, regardless of
+// whether "p.Session" points at a human's *Session or a Bot.
+type GameSession interface {
+	Emit(name string, data interface{})
+	NotifyGameAction(a Action, g *Game)
+	NotifySpectators(g *Game)
+	NotifyClock(g *Game)
+	NotifyGameHistory(h []models.GameHistory)
+	NotifyReplayLeader(g *Game)
+	NotifyAllNotes(notes []models.PlayerNote)
+	Set(key string, value interface{})
+	UserID() int
+}
+
+// natsConn is the shared NATS connection used to back bot sessions, set up
+// during boot; it remains nil (and bots fall back to purely in-process
+// behavior) if no broker is configured
+var natsConn *nats.Conn
+
+// botEvent is the envelope published for every outbound message that would
+// otherwise have gone out over a websocket
+type botEvent struct {
+	Name string      `json:"name"`
+	Data interface{} `json:"data"`
+}
+
+// Bot is a GameSession backed by a message bus instead of a websocket, so
+// that an external AI solver can join and play a table the same way a human
+// does. Outbound events are published to "hanabi.bot.<userID>.events" and
+// inbound commands are read from "hanabi.bot.<userID>.actions", where
+// "userID" is the bot's own (real, database-backed) user ID. Keying the
+// subject on the user ID rather than the display name means the subject is
+// not controlled by whatever name the inviting player chose; since
+// allocateBotUserID reuses one user ID per name, two bots invited under the
+// same name share the same subjects. When no NATS connection is configured
+// (e.g. in a single-process deployment or in tests), inbound commands are
+// fed through the in-process "actions" channel instead, and outbound events
+// are not delivered anywhere.
+type Bot struct {
+	userID   int
+	name     string
+	nc       *nats.Conn
+	actions  chan CommandData // Used directly when "nc" is nil
+	settings map[string]interface{}
+	ready    bool
+}
+
+// NewBot creates a bot session bound to the given (real) user ID and
+// subscribes it to its inbound action subject. If "nc" is nil, the bot falls
+// back to an in-process channel and "actions" should be fed by the caller
+// directly.
+func NewBot(userID int, name string, nc *nats.Conn) (*Bot, error) {
+	b := &Bot{
+		userID:   userID,
+		name:     name,
+		nc:       nc,
+		actions:  make(chan CommandData, 16),
+		settings: make(map[string]interface{}),
+	}
+
+	if nc == nil {
+		return b, nil
+	}
+
+	subject := b.actionsSubject()
+	if _, err := nc.Subscribe(subject, func(msg *nats.Msg) {
+		var d CommandData
+		if err := json.Unmarshal(msg.Data, &d); err != nil {
+			log.Error("Failed to unmarshal a bot action on \""+subject+"\":", err)
+			return
+		}
+		b.actions <- d
+	}); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Bot) eventsSubject() string {
+	return "hanabi.bot." + strconv.Itoa(b.userID) + ".events"
+}
+
+func (b *Bot) actionsSubject() string {
+	return "hanabi.bot." + strconv.Itoa(b.userID) + ".actions"
+}
+
+func (b *Bot) publish(name string, data interface{}) {
+	if b.nc == nil {
+		// No broker configured; there is nothing to publish to, since the bot
+		// driver is expected to be in the same process in this configuration
+		return
+	}
+
+	event := botEvent{
+		Name: name,
+		Data: data,
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Error("Failed to marshal a bot event:", err)
+		return
+	}
+
+	if err := b.nc.Publish(b.eventsSubject(), payload); err != nil {
+		log.Error("Failed to publish a bot event on \""+b.eventsSubject()+"\":", err)
+	}
+}
+
+// botReadyCommandType is a CommandData.Type value reserved for the bot
+// action subject only; it is how a bot acks the "init" handshake and is
+// intercepted by Run below instead of being forwarded to the generic command
+// dispatcher, which has no notion of bot readiness.
+const botReadyCommandType = -1
+
+// Run feeds inbound actions from the bus into the normal command handler,
+// exactly as if they had arrived over a websocket. It is meant to be called
+// in a new goroutine for the lifetime of the bot's participation in a table.
+func (b *Bot) Run() {
+	for d := range b.actions {
+		commandMutex.Lock()
+		if d.Type == botReadyCommandType {
+			b.Set("ready", true)
+		} else {
+			commandAction(b, &d)
+		}
+		commandMutex.Unlock()
+	}
+}
+
+// UserID implements GameSession
+func (b *Bot) UserID() int {
+	return b.userID
+}
+
+// Set implements GameSession
+func (b *Bot) Set(key string, value interface{}) {
+	b.settings[key] = value
+	if key == "ready" {
+		if ready, ok := value.(bool); ok {
+			b.ready = ready
+		}
+	}
+}
+
+// Emit implements GameSession
+func (b *Bot) Emit(name string, data interface{}) {
+	b.publish(name, data)
+}
+
+// NotifyGameAction implements GameSession
+func (b *Bot) NotifyGameAction(a Action, g *Game) {
+	b.publish("action", a)
+}
+
+// NotifySpectators implements GameSession
+func (b *Bot) NotifySpectators(g *Game) {
+	b.publish("spectators", g.Spectators)
+}
+
+// NotifyClock implements GameSession
+func (b *Bot) NotifyClock(g *Game) {
+	type ClockMessage struct {
+		Deadline string `json:"deadline"`
+	}
+	b.publish("clock", &ClockMessage{
+		Deadline: g.Deadline.UTC().Format("2006-01-02T15:04:05.000Z"),
+	})
+}
+
+// NotifyGameHistory implements GameSession
+func (b *Bot) NotifyGameHistory(h []models.GameHistory) {
+	b.publish("gameHistory", h)
+}
+
+// NotifyReplayLeader implements GameSession
+func (b *Bot) NotifyReplayLeader(g *Game) {
+	b.publish("replayLeader", g.Owner)
+}
+
+// NotifyAllNotes implements GameSession
+func (b *Bot) NotifyAllNotes(notes []models.PlayerNote) {
+	b.publish("notes", notes)
+}
+
+// commandInviteBot handles the "/invitebot <name>" lobby command, spawning a
+// bot session and seating it at the table. The table must have
+// "Options.AllowBots" set by its owner, since a bot occupies a seat that a
+// human could otherwise take.
+func commandInviteBot(s *Session, d *CommandData) {
+	g, ok := games[d.TableID]
+	if !ok {
+		s.Error("That table does not exist.")
+		return
+	}
+
+	if !g.Options.AllowBots {
+		s.Error("The owner of this table has not enabled bots.")
+		return
+	}
+
+	if g.Running {
+		s.Error("You cannot invite a bot to a game that has already started.")
+		return
+	}
+
+	userID, err := allocateBotUserID(d.Name)
+	if err != nil {
+		log.Error("Failed to allocate a user ID for a bot:", err)
+		s.Error("Failed to invite the bot. Please contact an administrator.")
+		return
+	}
+
+	bot, err := NewBot(userID, d.Name, natsConn)
+	if err != nil {
+		log.Error("Failed to create a bot session:", err)
+		s.Error("Failed to invite the bot. Please contact an administrator.")
+		return
+	}
+	go bot.Run()
+
+	g.Players = append(g.Players, &Player{
+		ID:      bot.userID,
+		Name:    bot.name,
+		Session: bot,
+		Present: true,
+	})
+	g.NotifyPlayerChange()
+
+	// Ask the bot to acknowledge the table state before it is allowed to
+	// play; the bot driver is expected to reply on its actions subject with
+	// {"type": botReadyCommandType}, which Run intercepts to flip "ready"
+	bot.Emit("init", &struct {
+		TableID int `json:"tableId"`
+	}{
+		TableID: g.ID,
+	})
+}
+
+// allocateBotUserID returns a real, persistent user ID for a bot account
+// with the given name, creating one the first time that name is seen. This
+// keeps bot participants indistinguishable from human ones when Game.End
+// writes "game_participants" rows via "db.GameParticipants.Insert(p.ID, ...)".
+func allocateBotUserID(name string) (int, error) {
+	if id, ok, err := db.Users.GetID(name); err != nil {
+		return 0, err
+	} else if ok {
+		return id, nil
+	}
+
+	return db.Users.InsertBot(name)
+}
+
+// botsReady reports whether every bot seated at the table has completed the
+// readiness handshake, so that the game is only marked "Running" once all of
+// its bots have acknowledged the initial state
+func botsReady(g *Game) bool {
+	for _, p := range g.Players {
+		if b, ok := p.Session.(*Bot); ok && !b.ready {
+			return false
+		}
+	}
+	return true
+}
+
+// TryStart is the gate that the owner-initiated "Start Game" handler
+// (commandGameStart) should call instead of setting "g.Running" directly: it
+// refuses to start a table that has an unacknowledged bot seated at it, and
+// otherwise only sets "g.Running" and "g.DatetimeStarted"; nothing else of
+// the start-game flow is done here. Once running, a silent bot is handled by
+// the same CheckTimer goroutine that ends the game when a silent human's
+// clock runs out, since "GameSession" makes the two indistinguishable to the
+// rest of the game logic.
+//
+// NOTE: commandGameStart itself lives outside this file and is not modified
+// here, so this gate is not wired into the live "Start Game" button yet;
+// until that handler calls TryStart, an owner can still start a table before
+// every invited bot has acked "ready".
+func (g *Game) TryStart() bool {
+	if !botsReady(g) {
+		return false
+	}
+
+	g.Running = true
+	g.DatetimeStarted = time.Now()
+	return true
+}
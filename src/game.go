@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"strconv"
 	"time"
 
+	"github.com/Zamiell/hanabi-live/src/gamelog"
 	"github.com/Zamiell/hanabi-live/src/models"
 )
 
@@ -17,6 +19,7 @@ type Game struct {
 	DisconSpectators map[int]bool
 	Running          bool
 	SharedReplay     bool
+	Recovered        bool // Rebuilt from the write-ahead log after a crash; see recovery.go
 	DatetimeCreated  time.Time
 	DatetimeStarted  time.Time
 	DatetimeFinished time.Time
@@ -36,6 +39,7 @@ type Game struct {
 	DiscardSignal *DiscardSignal
 	Sound         string
 	TurnBeginTime time.Time
+	Deadline      time.Time // The time by which the active player must perform an action
 	EndTurn       int
 	BlindPlays    int // The number of consecutive blind plays
 }
@@ -46,6 +50,7 @@ type Options struct {
 	TimeBase     float64
 	TimePerTurn  int
 	ReorderCards bool
+	AllowBots    bool // Whether the table owner allows bot players to be invited
 }
 
 type DiscardSignal struct {
@@ -79,6 +84,88 @@ func (g *Game) MaxScore() int {
 	return len(g.Stacks) * 5
 }
 
+// EndConditionAbandoned marks a game that was terminated because every
+// player disconnected and never came back, as opposed to a normal finish or
+// a strikeout; see "database_schema.sql" for the full list of conditions.
+const EndConditionAbandoned = 3
+
+// GetDeadline returns the authoritative time by which the active player must perform
+// an action, taking the lower of their personal clock and the "time per turn" cap
+func (g *Game) GetDeadline(p *Player) time.Time {
+	deadline := g.TurnBeginTime.Add(p.Time)
+	if g.Options.TimePerTurn > 0 {
+		if turnDeadline := g.TurnBeginTime.Add(time.Duration(g.Options.TimePerTurn) * time.Second); turnDeadline.Before(deadline) {
+			deadline = turnDeadline
+		}
+	}
+	return deadline
+}
+
+// SetDeadline recomputes the deadline for the active player and notifies everyone
+// in the game (and the lobby) so that clients can render an authoritative countdown
+// instead of extrapolating from a potentially drifting local clock
+func (g *Game) SetDeadline() {
+	if g.ActivePlayer < 0 || g.ActivePlayer >= len(g.Players) {
+		g.Deadline = time.Time{}
+		return
+	}
+
+	g.Deadline = g.GetDeadline(g.Players[g.ActivePlayer])
+	g.NotifyTime()
+}
+
+// AppendAction adds an action to the in-memory action log and persists it to
+// the write-ahead log, so that the action is not lost if the server crashes
+// before the game finishes and its actions are flushed to the database
+func (g *Game) AppendAction(a Action) {
+	g.Actions = append(g.Actions, a)
+
+	if !gamelog.Enabled() {
+		return
+	}
+
+	actionJSON, err := json.Marshal(a)
+	if err != nil {
+		log.Error(g.GetName()+"Failed to marshal an action for the write-ahead log:", err)
+		return
+	}
+
+	optionsJSON, err := json.Marshal(g.Options)
+	if err != nil {
+		log.Error(g.GetName()+"Failed to marshal the options for the write-ahead log:", err)
+		return
+	}
+
+	players := make([]gamelog.PlayerInfo, 0, len(g.Players))
+	for _, p := range g.Players {
+		players = append(players, gamelog.PlayerInfo{
+			ID:    p.ID,
+			Name:  p.Name,
+			Time:  p.Time,
+			Notes: append([]string(nil), p.Notes...),
+		})
+	}
+
+	entry := gamelog.Entry{
+		Turn:            g.Turn,
+		ActivePlayer:    g.ActivePlayer,
+		DatetimeCreated: time.Now(),
+		Seed:            g.Seed,
+		Options:         optionsJSON,
+		Players:         players,
+		Score:           g.Score,
+		Strikes:         g.Strikes,
+		Clues:           g.Clues,
+		DeckIndex:       g.DeckIndex,
+		EndTurn:         g.EndTurn,
+		Stacks:          append([]int(nil), g.Stacks...),
+		Action:          actionJSON,
+	}
+	if err := gamelog.Append(g.ID, entry); err != nil {
+		log.Error(g.GetName()+"Failed to append an action to the write-ahead log:", err)
+	}
+}
+
 /*
 	Notify functions
 */
@@ -189,12 +276,43 @@ func (g *Game) NotifySpectators() {
 
 func (g *Game) NotifyTime() {
 	for _, p := range g.Players {
+		// A player recovered from the write-ahead log has no session until
+		// they reconnect; there is no one to notify yet
+		if p.Session == nil {
+			continue
+		}
 		p.Session.NotifyClock(g)
 	}
 
 	for _, s := range g.Spectators {
 		s.NotifyClock(g)
 	}
+
+	// Let the lobby know about the new deadline as well, so that tournament
+	// observers can render a countdown without keeping their own drift-prone timer
+	notifyAllTableTurnDeadline(g)
+}
+
+// notifyAllTableTurnDeadline broadcasts the current turn's deadline to every
+// connected session in the lobby, not just this game's players and
+// spectators, so that a tournament observer watching the lobby can render a
+// countdown bar for a table they have not joined as a spectator
+func notifyAllTableTurnDeadline(g *Game) {
+	type TurnDeadlineMessage struct {
+		TableID  int    `json:"tableID"`
+		Deadline string `json:"deadline,omitempty"`
+	}
+
+	data := &TurnDeadlineMessage{
+		TableID: g.ID,
+	}
+	if !g.Deadline.IsZero() {
+		data.Deadline = g.Deadline.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	for _, s := range sessions {
+		s.Emit("turnDeadline", data)
+	}
 }
 
 func (g *Game) NotifySound() {
@@ -277,10 +395,46 @@ func (g *Game) NotifySpectatorsNote(order int) {
 	Other major functions
 */
 
-// This function is meant to be called in a new goroutine
+// timerPollInterval bounds how long CheckTimer can sleep before re-reading
+// "g.Deadline"; code that shortens a deadline mid-turn (e.g. the idle-player
+// janitor's clamp) is guaranteed to take effect within this long.
+const timerPollInterval = time.Second
+
+// This function is meant to be called in a new goroutine, right as a new
+// turn begins for "p"
 func (g *Game) CheckTimer(turn int, p *Player) {
-	// Sleep until the active player runs out of time
-	time.Sleep(p.Time)
+	// This is the turn's authoritative deadline; computing and broadcasting it
+	// here (rather than trusting the caller to have done so already) means the
+	// goroutine's sleep target and the value shown on every client always agree
+	commandMutex.Lock()
+	g.SetDeadline()
+	commandMutex.Unlock()
+
+	// Sleep against "g.Deadline" in short increments rather than in one long
+	// sleep, so that a deadline shortened mid-turn (e.g. the idle-player janitor
+	// clamping a disconnected player's remaining time) is noticed promptly
+	// instead of being slept through
+	for {
+		commandMutex.Lock()
+		ended := g.EndCondition > 0
+		sameTurn := turn == g.Turn
+		remaining := time.Until(g.Deadline)
+		commandMutex.Unlock()
+
+		if ended || !sameTurn {
+			return
+		}
+		if remaining <= 0 {
+			break
+		}
+
+		sleepFor := remaining
+		if sleepFor > timerPollInterval {
+			sleepFor = timerPollInterval
+		}
+		time.Sleep(sleepFor)
+	}
+
 	commandMutex.Lock()
 	defer commandMutex.Unlock()
 
@@ -307,24 +461,18 @@ func (g *Game) CheckTimer(turn int, p *Player) {
 func (g *Game) CheckEnd() bool {
 	// Check for 3 strikes
 	if g.Strikes == 3 {
-		log.Info(g.GetName() + "3 strike maximum reached; ending the game.")
-		g.EndCondition = 2
-		return true
+		return g.end(2, "3 strike maximum reached; ending the game.")
 	}
 
 	// Check for the final go-around
 	// (initiated after the last card is played from the deck)
 	if g.Turn == g.EndTurn {
-		log.Info(g.GetName() + "Final turn reached; ending the game.")
-		g.EndCondition = 1
-		return true
+		return g.end(1, "Final turn reached; ending the game.")
 	}
 
 	// Check to see if the maximum score has been reached
 	if g.Score == g.MaxScore() {
-		log.Info(g.GetName() + "Maximum score reached; ending the game.")
-		g.EndCondition = 1
-		return true
+		return g.end(1, "Maximum score reached; ending the game.")
 	}
 
 	// Check to see if there are any cards remaining that can be played on the stacks
@@ -343,7 +491,15 @@ func (g *Game) CheckEnd() bool {
 	}
 
 	// If we got this far, nothing can be played
-	log.Info(g.GetName() + "No remaining cards can be played; ending the game.")
-	g.EndCondition = 1
+	return g.end(1, "No remaining cards can be played; ending the game.")
+}
+
+// end marks the game as over with the given end condition and invalidates the
+// turn deadline, so that a CheckTimer goroutine sleeping on a stale deadline
+// cannot race with the "game already ended" notification
+func (g *Game) end(condition int, msg string) bool {
+	log.Info(g.GetName() + msg)
+	g.EndCondition = condition
+	g.Deadline = time.Time{}
 	return true
 }
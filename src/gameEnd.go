@@ -6,6 +6,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Zamiell/hanabi-live/src/gamelog"
 	"github.com/Zamiell/hanabi-live/src/models"
 )
 
@@ -16,7 +17,7 @@ func (g *Game) End() {
 	// (even show them in non-timed games in case people are going for a speedrun)
 	if g.Options.Timed {
 		// Advance a turn so that we have an extra separator before the finishing times
-		g.Actions = append(g.Actions, Action{
+		g.AppendAction(Action{
 			Type: "turn",
 			Num:  g.Turn,
 			Who:  g.ActivePlayer,
@@ -25,7 +26,7 @@ func (g *Game) End() {
 
 		for _, p := range g.Players {
 			text := p.Name + " finished with a time of " + durationToString(p.Time)
-			g.Actions = append(g.Actions, Action{
+			g.AppendAction(Action{
 				Text: text,
 			})
 			// But don't notify the players; the finishing times will only appear in the replay
@@ -40,7 +41,7 @@ func (g *Game) End() {
 		totalTime *= -1 // The duration will be negative since the clocks start at 0
 
 		text := "The total game duration was: " + durationToString(totalTime)
-		g.Actions = append(g.Actions, Action{
+		g.AppendAction(Action{
 			Text: text,
 		})
 		// But don't notify the players; the finishing times will only appear in the replay
@@ -52,7 +53,7 @@ func (g *Game) End() {
 	if g.EndCondition > 1 {
 		loss = true
 	}
-	g.Actions = append(g.Actions, Action{
+	g.AppendAction(Action{
 		Type:  "gameOver",
 		Score: g.Score,
 		Loss:  loss,
@@ -61,6 +62,7 @@ func (g *Game) End() {
 
 	// Send everyone a clock message with an active value of null, which
 	// will get rid of the timers on the client-side
+	g.Deadline = time.Time{}
 	g.NotifyTime()
 
 	// Send "reveal" messages to each player about the missing cards in their hand
@@ -137,6 +139,12 @@ func (g *Game) End() {
 		}
 	}
 
+	// Now that every action has been safely committed to the database,
+	// the write-ahead log for this game is no longer needed
+	if err := gamelog.Delete(g.ID); err != nil {
+		log.Error("Failed to delete the write-ahead log for the game:", err)
+	}
+
 	var numSimilar int
 	if v, err := db.Games.GetNumSimilar(g.Seed); err != nil {
 		log.Error("Failed to get the number of games on seed "+g.Seed+":", err)
@@ -171,6 +179,9 @@ func (g *Game) End() {
 	// Send a chat message with the game result and players
 	announceGameResult(g, databaseID)
 
+	// Update each player's matchmaking rating now that the outcome is known
+	updateMatchmakingRatings(g)
+
 	log.Info("Finished database actions for the end of the game.")
 
 	// Turn the game into a shared replay
@@ -207,7 +218,14 @@ func (g *Game) End() {
 			continue
 		}
 
-		g.Spectators[p.Session.UserID()] = p.Session
+		// Bots never spectate; "Spectators" is keyed on the concrete *Session
+		// type, not the GameSession interface, since only humans stick around
+		// to watch a shared replay
+		s, ok := p.Session.(*Session)
+		if !ok {
+			continue
+		}
+		g.Spectators[s.UserID()] = s
 	}
 
 	// Empty the players
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDeadlineUsesThePlayersOwnClockWhenUntimed(t *testing.T) {
+	begin := time.Unix(1700000000, 0)
+	g := &Game{
+		Options:       &Options{},
+		TurnBeginTime: begin,
+	}
+	p := &Player{Time: 2 * time.Minute}
+
+	want := begin.Add(2 * time.Minute)
+	if got := g.GetDeadline(p); !got.Equal(want) {
+		t.Fatalf("GetDeadline() = %v, want %v", got, want)
+	}
+}
+
+func TestGetDeadlineCapsAtTimePerTurn(t *testing.T) {
+	begin := time.Unix(1700000000, 0)
+	g := &Game{
+		Options:       &Options{TimePerTurn: 30}, // 30 second cap
+		TurnBeginTime: begin,
+	}
+	p := &Player{Time: 2 * time.Minute} // Plenty of clock left, but the turn cap is tighter
+
+	want := begin.Add(30 * time.Second)
+	if got := g.GetDeadline(p); !got.Equal(want) {
+		t.Fatalf("GetDeadline() = %v, want %v", got, want)
+	}
+}
+
+func TestGetDeadlinePrefersThePlayersClockWhenItIsTighter(t *testing.T) {
+	begin := time.Unix(1700000000, 0)
+	g := &Game{
+		Options:       &Options{TimePerTurn: 120},
+		TurnBeginTime: begin,
+	}
+	p := &Player{Time: 10 * time.Second} // About to run out, well under the turn cap
+
+	want := begin.Add(10 * time.Second)
+	if got := g.GetDeadline(p); !got.Equal(want) {
+		t.Fatalf("GetDeadline() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+// Package gamelog implements a write-ahead log for in-progress games.
+//
+// Game.Actions is normally only flushed to the database in Game.End, so a
+// server crash in the middle of a game would otherwise lose every action and
+// drop the game from the "games" table entirely. Each running game gets its
+// own append-only, fsync'd JSON-lines file under the directory passed to
+// Init; on restart, any file
+// that doesn't correspond to a finished game in the database can be replayed
+// to reconstruct the in-memory Game struct.
+//
+// This only restores the score, turn, clues, stacks, deck position, and each
+// player's clock/notes — everything recorded in Entry. It does not snapshot
+// per-player hands or the rest of the deck's shuffled/discarded state, so a
+// recovered game cannot actually resume live play; the recovering side is
+// expected to mark it accordingly (see Game.Recovered in package main)
+// instead of treating it as a normal in-progress table.
+package gamelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PlayerInfo identifies a seated player and the part of their state that
+// changes turn-to-turn, so that recovery can rebuild the player list without
+// needing a finished "game_participants" row
+type PlayerInfo struct {
+	ID    int           `json:"id"`
+	Name  string        `json:"name"`
+	Time  time.Duration `json:"time"`
+	Notes []string      `json:"notes,omitempty"`
+}
+
+// Entry represents a single logged action, along with a snapshot of the
+// derived game state right after that action was applied. Recovery restores
+// the snapshot directly rather than re-deriving it by re-running the action
+// through the normal command handlers, since those handlers depend on a
+// fully-seeded deck and validation state that a bare Action does not carry.
+type Entry struct {
+	Turn            int             `json:"turn"`
+	ActivePlayer    int             `json:"activePlayer"`
+	DatetimeCreated time.Time       `json:"datetimeCreated"`
+	Seed            string          `json:"seed"`
+	Options         json.RawMessage `json:"options,omitempty"`
+	Players         []PlayerInfo    `json:"players,omitempty"`
+	Score           int             `json:"score"`
+	Strikes         int             `json:"strikes"`
+	Clues           int             `json:"clues"`
+	DeckIndex       int             `json:"deckIndex"`
+	EndTurn         int             `json:"endTurn"`
+	Stacks          []int           `json:"stacks"`
+	Action          json.RawMessage `json:"action"`
+}
+
+var (
+	dir     string
+	enabled bool
+)
+
+// Init configures the write-ahead log subsystem
+// (dir is created if it does not already exist)
+func Init(logDir string, logEnabled bool) error {
+	dir = logDir
+	enabled = logEnabled
+
+	if !enabled {
+		return nil
+	}
+
+	return os.MkdirAll(dir, 0755)
+}
+
+// Enabled reports whether the write-ahead log is turned on
+// (it is normally disabled for tests, since it touches the filesystem)
+func Enabled() bool {
+	return enabled
+}
+
+func path(gameID int) string {
+	return filepath.Join(dir, strconv.Itoa(gameID)+".log")
+}
+
+// Append writes one entry to the game's WAL file, creating it if necessary,
+// and fsyncs before returning so that the entry survives a crash
+func Append(gameID int, entry Entry) error {
+	if !enabled {
+		return nil
+	}
+
+	f, err := os.OpenFile(path(gameID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Delete removes a game's WAL file
+// (this should be called once the game's actions have been safely committed
+// to the database in Game.End)
+func Delete(gameID int) error {
+	if !enabled {
+		return nil
+	}
+
+	if err := os.Remove(path(gameID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List returns the game IDs of every WAL file currently on disk
+func List() ([]int, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+
+		idString := strings.TrimSuffix(name, ".log")
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// Read replays a game's WAL file back into a slice of entries, in the order
+// that they were originally appended
+func Read(gameID int) ([]Entry, error) {
+	f, err := os.Open(path(gameID))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	// The scanner's default 64KB token size is too small for a big "notesWritten"
+	// style action, so allow entries up to 1MB
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// Prune deletes WAL files that are older than maxAge, returning how many were
+// removed. It is meant to be run periodically to clean up files left behind
+// by games that were recovered and then abandoned again before finishing.
+func Prune(maxAge time.Duration) (int, error) {
+	if !enabled {
+		return 0, nil
+	}
+
+	ids, err := List()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	pruned := 0
+	for _, id := range ids {
+		info, err := os.Stat(path(id))
+		if err != nil {
+			continue
+		}
+
+		if info.ModTime().Before(cutoff) {
+			if err := Delete(id); err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+	}
+
+	return pruned, nil
+}
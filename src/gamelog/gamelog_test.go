@@ -0,0 +1,140 @@
+package gamelog
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func withTempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "gamelog-test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	if err := Init(dir, true); err != nil {
+		t.Fatalf("failed to init gamelog: %v", err)
+	}
+
+	return dir
+}
+
+func TestAppendAndRead(t *testing.T) {
+	withTempDir(t)
+
+	entries := []Entry{
+		{Turn: 0, ActivePlayer: 0, Seed: "p1", Score: 0, Action: []byte(`{"type":"draw"}`)},
+		{Turn: 1, ActivePlayer: 1, Seed: "p1", Score: 1, Action: []byte(`{"type":"play"}`)},
+	}
+	for _, entry := range entries {
+		if err := Append(1, entry); err != nil {
+			t.Fatalf("failed to append entry: %v", err)
+		}
+	}
+
+	got, err := Read(1)
+	if err != nil {
+		t.Fatalf("failed to read entries: %v", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), len(got))
+	}
+	for i, entry := range entries {
+		if got[i].Turn != entry.Turn || got[i].Score != entry.Score {
+			t.Errorf("entry %d: expected turn=%d score=%d, got turn=%d score=%d",
+				i, entry.Turn, entry.Score, got[i].Turn, got[i].Score)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	withTempDir(t)
+
+	for _, id := range []int{2, 5, 9} {
+		if err := Append(id, Entry{Turn: 0}); err != nil {
+			t.Fatalf("failed to append entry for game %d: %v", id, err)
+		}
+	}
+
+	ids, err := List()
+	if err != nil {
+		t.Fatalf("failed to list: %v", err)
+	}
+
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, id := range []int{2, 5, 9} {
+		if !seen[id] {
+			t.Errorf("expected game %d to be listed, got %v", id, ids)
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	withTempDir(t)
+
+	if err := Append(3, Entry{Turn: 0}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+	if err := Delete(3); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	if _, err := Read(3); err == nil {
+		t.Fatal("expected reading a deleted game's WAL file to fail")
+	}
+
+	// Deleting a file that is already gone should not be an error
+	if err := Delete(3); err != nil {
+		t.Fatalf("expected deleting an already-deleted file to be a no-op, got: %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	withTempDir(t)
+
+	if err := Append(4, Entry{Turn: 0}); err != nil {
+		t.Fatalf("failed to append: %v", err)
+	}
+
+	// A file that was just written is not old enough to prune
+	pruned, err := Prune(time.Hour)
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if pruned != 0 {
+		t.Fatalf("expected 0 files pruned, got %d", pruned)
+	}
+
+	// Any file is "older" than a zero maxAge
+	pruned, err = Prune(0)
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected 1 file pruned, got %d", pruned)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if err := Init(t.TempDir(), false); err != nil {
+		t.Fatalf("failed to init gamelog: %v", err)
+	}
+
+	if Enabled() {
+		t.Fatal("expected gamelog to be disabled")
+	}
+	if err := Append(1, Entry{}); err != nil {
+		t.Fatalf("expected Append to be a no-op when disabled, got: %v", err)
+	}
+	if ids, err := List(); err != nil || ids != nil {
+		t.Fatalf("expected List to be a no-op when disabled, got ids=%v err=%v", ids, err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	janitorInterval = 30 * time.Second
+
+	idleActivePlayerThreshold = 5 * time.Minute  // Non-timed games: end once the active player is offline and their turn has lasted this long
+	allOfflineThreshold       = 15 * time.Minute // Abandon a game once everyone has been gone this long
+	idleOwnerThreshold        = 10 * time.Minute // Delete a pre-game table once its owner has been gone this long
+	idleTurnTimeCap           = 2 * time.Minute  // Timed games: clamp a disconnected player's remaining time to this
+)
+
+// allOfflineSince and ownerOfflineSince track how long a game has
+// continuously been in a reapable state; a game is removed from
+// allOfflineSince as soon as any player shows back up, and from
+// ownerOfflineSince as soon as the owner does, so a brief disconnect never
+// counts against it.
+var (
+	allOfflineSince   = make(map[int]time.Time)
+	ownerOfflineSince = make(map[int]time.Time)
+)
+
+// StartJanitor runs forever in its own goroutine, periodically sweeping the
+// lobby for idle players and abandoned games so that a table no one is
+// actually playing does not sit around forever.
+func StartJanitor() {
+	for {
+		time.Sleep(janitorInterval)
+		commandMutex.Lock()
+		runJanitorPass()
+		commandMutex.Unlock()
+	}
+}
+
+func runJanitorPass() {
+	for _, g := range games {
+		// A game rebuilt from the write-ahead log has no sessions and cannot be
+		// played or ended normally; see recoverGame
+		if g.SharedReplay || g.Recovered {
+			continue
+		}
+
+		if !g.Running {
+			reapIdlePreGameTable(g)
+			continue
+		}
+
+		if reapAbandonedGame(g) {
+			continue
+		}
+
+		reapIdleActivePlayer(g)
+		clampIdleActivePlayerTime(g)
+	}
+}
+
+// reapIdleActivePlayer ends a non-timed game the same way CheckTimer ends a
+// timed one, once the active player is offline and their turn has lasted long
+// enough that the rest of the table is effectively stalled waiting on them.
+// The threshold is measured from the start of the turn; how long the player
+// has actually been offline is not tracked.
+func reapIdleActivePlayer(g *Game) {
+	if g.Options.Timed {
+		// Timed games are already covered by CheckTimer's own clock
+		return
+	}
+
+	if g.ActivePlayer < 0 || g.ActivePlayer >= len(g.Players) {
+		return
+	}
+
+	p := g.Players[g.ActivePlayer]
+	if p.Present || time.Since(g.TurnBeginTime) < idleActivePlayerThreshold {
+		return
+	}
+
+	log.Info(g.GetName() + "\"" + p.Name + "\" has been idle for too long; ending the game.")
+	d := &CommandData{
+		Type: 4,
+	}
+	commandAction(p.Session, d)
+}
+
+// reapAbandonedGame ends a game that every player has disconnected from,
+// recording it with a distinct end condition so that it does not pollute
+// anyone's win-rate statistics. It returns true if the game was ended.
+func reapAbandonedGame(g *Game) bool {
+	allOffline := true
+	for _, p := range g.Players {
+		if p.Present {
+			allOffline = false
+			break
+		}
+	}
+
+	if !allOffline {
+		delete(allOfflineSince, g.ID)
+		return false
+	}
+
+	since, ok := allOfflineSince[g.ID]
+	if !ok {
+		allOfflineSince[g.ID] = time.Now()
+		return false
+	}
+
+	if time.Since(since) < allOfflineThreshold {
+		return false
+	}
+
+	log.Info(g.GetName() + "Every player has been offline for too long; abandoning the game.")
+	delete(allOfflineSince, g.ID)
+	g.EndCondition = EndConditionAbandoned
+	g.End()
+	return true
+}
+
+// reapIdlePreGameTable deletes a pre-game table once its owner has been gone
+// long enough that the table is never going to start
+func reapIdlePreGameTable(g *Game) {
+	i := g.GetIndex(g.Owner)
+	if i == -1 {
+		delete(ownerOfflineSince, g.ID)
+		return
+	}
+
+	owner := g.Players[i]
+	if owner.Present {
+		delete(ownerOfflineSince, g.ID)
+		return
+	}
+
+	since, ok := ownerOfflineSince[g.ID]
+	if !ok {
+		ownerOfflineSince[g.ID] = time.Now()
+		return
+	}
+
+	if time.Since(since) < idleOwnerThreshold {
+		return
+	}
+
+	log.Info(g.GetName() + "The owner has been offline for too long; deleting the table.")
+	delete(ownerOfflineSince, g.ID)
+	delete(games, g.ID)
+	notifyAllTableGone(g)
+}
+
+// clampIdleActivePlayerTime prevents a disconnected player in a timed game
+// from stalling the table for the length of their entire remaining clock;
+// on the first janitor pass that finds them offline, their remaining time is
+// capped and
+// "g.Deadline" is recomputed. CheckTimer re-reads "g.Deadline" at least every
+// timerPollInterval rather than sleeping once against the original deadline,
+// so the clamp takes effect within that window instead of only mattering the
+// next time a fresh CheckTimer goroutine happens to start.
+func clampIdleActivePlayerTime(g *Game) {
+	if !g.Options.Timed || g.ActivePlayer < 0 || g.ActivePlayer >= len(g.Players) {
+		return
+	}
+
+	p := g.Players[g.ActivePlayer]
+	if p.Present || p.Time <= idleTurnTimeCap {
+		return
+	}
+
+	p.Time = idleTurnTimeCap
+	g.SetDeadline()
+}
+
+// commandKick handles the owner-only "/kick <name>" command. It does not call
+// the janitor's reaper functions; it repeats their steps inline: before the
+// game starts, it deletes the whole table (not only the kicked player's
+// seat), as reapIdlePreGameTable does, and once the game is running, it ends
+// the game with the same timeout action that reapIdleActivePlayer sends
+func commandKick(s *Session, d *CommandData) {
+	g, ok := games[d.TableID]
+	if !ok {
+		s.Error("That table does not exist.")
+		return
+	}
+
+	if s.UserID() != g.Owner {
+		s.Error("Only the owner of the table can kick a player.")
+		return
+	}
+
+	i := g.GetIndex(d.UserID)
+	if i == -1 {
+		s.Error("That player is not at the table.")
+		return
+	}
+
+	if !g.Running {
+		log.Info(g.GetName() + "\"" + g.Players[i].Name + "\" was kicked by the owner before the game started.")
+		delete(ownerOfflineSince, g.ID)
+		delete(games, g.ID)
+		notifyAllTableGone(g)
+		return
+	}
+
+	// A running Hanabi game cannot drop a single seat without corrupting the
+	// turn order and the hands that were dealt for the current player count,
+	// so kicking anyone once the game has started ends the table for
+	// everyone, exactly like letting the clock run out; warn the owner so
+	// that this is not a surprise, rather than silently doing it.
+	s.Error("Kicking a player from a game that has already started ends the game for every player, since a seat cannot be removed mid-game.")
+	log.Info(g.GetName() + "\"" + g.Players[i].Name + "\" was kicked by the owner, which ends the game for everyone since it had already started.")
+	cd := &CommandData{
+		Type: 4,
+	}
+	commandAction(g.Players[i].Session, cd)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// matchmakingKey groups queued sessions into pools of sessions willing to
+// play the same kind of game
+type matchmakingKey struct {
+	Variant     int
+	Timed       bool
+	PlayerCount int // The preferred number of players; 0 means "no preference"
+}
+
+// queuedSession is one session waiting in a matchmaking pool
+type queuedSession struct {
+	Session    *Session
+	UserID     int
+	Name       string
+	EnqueuedAt time.Time
+}
+
+const (
+	matchmakingMinPlayers   = 2
+	matchmakingMaxPlayers   = 5 // Hanabi does not support a 6th player
+	matchmakingWaitSeconds  = 60 // How long to wait for a 4th+ player before starting short-handed
+	matchmakingPollInterval = 5 * time.Second
+)
+
+var (
+	matchmakingMutex sync.Mutex
+	matchmakingQueue  = make(map[matchmakingKey][]*queuedSession)
+
+	ratingsMutex sync.Mutex
+	ratings      = make(map[int]map[int]int) // userID -> variant -> rating
+
+	nextMatchmakingGameID = 1000000 // Offset well clear of database-assigned game IDs
+)
+
+// commandQueue handles the "/queue <variant> [timed]" lobby command,
+// enqueuing the session into the pool for that variant/timed combination
+func commandQueue(s *Session, d *CommandData) {
+	key := matchmakingKey{
+		Variant:     d.Variant,
+		Timed:       d.Timed,
+		PlayerCount: d.PlayerCount,
+	}
+
+	matchmakingMutex.Lock()
+	matchmakingQueue[key] = append(matchmakingQueue[key], &queuedSession{
+		Session:    s,
+		UserID:     s.UserID(),
+		Name:       s.Username(),
+		EnqueuedAt: time.Now(),
+	})
+	matchmakingMutex.Unlock()
+
+	notifyMatchmakingUpdate(key)
+}
+
+// commandUnqueue removes a session from every pool it is waiting in
+// (e.g. when the user navigates away or closes the matchmaking dialog)
+func commandUnqueue(s *Session, d *CommandData) {
+	matchmakingMutex.Lock()
+	defer matchmakingMutex.Unlock()
+
+	for key, sessions := range matchmakingQueue {
+		for i, qs := range sessions {
+			if qs.UserID == s.UserID() {
+				matchmakingQueue[key] = append(sessions[:i], sessions[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// StartMatchmaker runs forever in its own goroutine, periodically forming
+// games out of compatible queued sessions. It is meant to be started once at
+// boot.
+func StartMatchmaker() {
+	for {
+		time.Sleep(matchmakingPollInterval)
+		commandMutex.Lock()
+		pollMatchmakingQueue()
+		commandMutex.Unlock()
+	}
+}
+
+// pollMatchmakingQueue forms a game out of any pool that is either full (for
+// its preferred player count) or has been waiting long enough to start
+// short-handed
+func pollMatchmakingQueue() {
+	matchmakingMutex.Lock()
+	defer matchmakingMutex.Unlock()
+
+	for key, sessions := range matchmakingQueue {
+		if len(sessions) < matchmakingMinPlayers {
+			continue
+		}
+
+		target := targetGroupSize(key, len(sessions))
+		full := len(sessions) >= target
+		waitedLongEnough := time.Since(sessions[0].EnqueuedAt) >= matchmakingWaitSeconds*time.Second
+		if !full && !waitedLongEnough {
+			continue
+		}
+
+		n := target
+		if n > len(sessions) {
+			n = len(sessions)
+		}
+
+		ordered := ratingBiasedOrder(sessions, key.Variant)
+		group := ordered[:n]
+		matchmakingQueue[key] = remainingSessions(sessions, group)
+
+		startMatchmadeGame(key, group)
+	}
+}
+
+// targetGroupSize returns how many players a pool should try to seat.
+// "matchmakingKey" guarantees every session in the pool asked for the same
+// preferred player count, so there is nothing to reconcile here; a
+// preference of 0 just means "take as many as are waiting, up to the cap."
+func targetGroupSize(key matchmakingKey, available int) int {
+	if key.PlayerCount == 0 {
+		if available > matchmakingMaxPlayers {
+			return matchmakingMaxPlayers
+		}
+		return available
+	}
+
+	target := key.PlayerCount
+	if target > matchmakingMaxPlayers {
+		target = matchmakingMaxPlayers
+	}
+	if target < matchmakingMinPlayers {
+		target = matchmakingMinPlayers
+	}
+	return target
+}
+
+// ratingBiasedOrder returns a pool's sessions in the order they should be
+// drafted into a game. The longest-waiting session is always first, so no
+// one is ever starved out by the bias; the rest are sorted by how close
+// their rating is to that session's, so that when a pool is bigger than the
+// group it is about to form, the most mismatched players are the ones left
+// behind to wait for a better game rather than whoever merely queued last.
+func ratingBiasedOrder(sessions []*queuedSession, variant int) []*queuedSession {
+	anchor := sessions[0]
+	anchorRating := getRating(anchor.UserID, variant)
+
+	rest := append([]*queuedSession(nil), sessions[1:]...)
+	sort.SliceStable(rest, func(i, j int) bool {
+		di := ratingDistance(rest[i].UserID, variant, anchorRating)
+		dj := ratingDistance(rest[j].UserID, variant, anchorRating)
+		if di != dj {
+			return di < dj
+		}
+		return rest[i].EnqueuedAt.Before(rest[j].EnqueuedAt)
+	})
+
+	return append([]*queuedSession{anchor}, rest...)
+}
+
+func ratingDistance(userID, variant, anchorRating int) int {
+	d := getRating(userID, variant) - anchorRating
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// remainingSessions returns the sessions still waiting after group has been
+// drafted out of sessions, preserving the original FIFO order so that
+// whoever was left behind keeps their place in line
+func remainingSessions(sessions []*queuedSession, group []*queuedSession) []*queuedSession {
+	drafted := make(map[int]bool, len(group))
+	for _, qs := range group {
+		drafted[qs.UserID] = true
+	}
+
+	rest := make([]*queuedSession, 0, len(sessions)-len(group))
+	for _, qs := range sessions {
+		if !drafted[qs.UserID] {
+			rest = append(rest, qs)
+		}
+	}
+	return rest
+}
+
+// getRating returns a player's matchmaking rating for a variant, defaulting
+// to 0 (neutral) for a player who has not finished a rated game in it yet
+func getRating(userID, variant int) int {
+	ratingsMutex.Lock()
+	defer ratingsMutex.Unlock()
+
+	return ratings[userID][variant]
+}
+
+// adjustRating applies a rating delta for a player in a variant; it is the
+// in-memory counterpart to "db.UserStats.UpdateRating" and is what actually
+// biases future pool selection, since the queue only ever runs in-process
+func adjustRating(userID, variant, delta int) {
+	ratingsMutex.Lock()
+	defer ratingsMutex.Unlock()
+
+	if ratings[userID] == nil {
+		ratings[userID] = make(map[int]int)
+	}
+	ratings[userID][variant] += delta
+}
+
+// allocateGameID picks an ID for a synthesized matchmaking table. A normal
+// game only gets its permanent ID when it is inserted into the database in
+// Game.End, so matchmade tables need their own numbering; starting well past
+// any realistic database ID keeps the two spaces from colliding in practice,
+// and the scan guards the case where they do anyway.
+func allocateGameID() int {
+	for {
+		id := nextMatchmakingGameID
+		nextMatchmakingGameID++
+		if _, ok := games[id]; !ok {
+			return id
+		}
+	}
+}
+
+// startMatchmadeGame synthesizes a table for the given group of queued
+// sessions and routes each of them in through the normal join path, with the
+// earliest-enqueued player becoming the owner
+func startMatchmadeGame(key matchmakingKey, group []*queuedSession) {
+	owner := group[0].UserID
+
+	g := &Game{
+		ID:    allocateGameID(),
+		Name:  "Matchmade game",
+		Owner: owner,
+		Options: &Options{
+			Variant:  key.Variant,
+			Timed:    key.Timed,
+			TimeBase: 120,
+		},
+		Seed:             "m" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		DatetimeCreated:  time.Now(),
+		Spectators:       make(map[int]*Session),
+		DisconSpectators: make(map[int]bool),
+		DiscardSignal:    &DiscardSignal{},
+	}
+	games[g.ID] = g
+
+	for _, qs := range group {
+		d := &CommandData{
+			TableID: g.ID,
+		}
+		commandGameJoin(qs.Session, d)
+	}
+
+	d := &CommandData{
+		TableID: g.ID,
+	}
+	commandGameStart(group[0].Session, d)
+}
+
+// notifyMatchmakingUpdate tells everyone in a pool where they stand in line,
+// piggybacking on the existing notifyAllUser-style per-session push
+func notifyMatchmakingUpdate(key matchmakingKey) {
+	matchmakingMutex.Lock()
+	sessions := append([]*queuedSession(nil), matchmakingQueue[key]...)
+	matchmakingMutex.Unlock()
+
+	type MatchmakingUpdateMessage struct {
+		Variant    int `json:"variant"`
+		Position   int `json:"position"`
+		PoolSize   int `json:"poolSize"`
+		ETASeconds int `json:"etaSeconds"`
+	}
+
+	for i, qs := range sessions {
+		eta := matchmakingWaitSeconds - int(time.Since(qs.EnqueuedAt).Seconds())
+		if eta < 0 {
+			eta = 0
+		}
+		qs.Session.Emit("matchmakingUpdate", &MatchmakingUpdateMessage{
+			Variant:    key.Variant,
+			Position:   i + 1,
+			PoolSize:   len(sessions),
+			ETASeconds: eta,
+		})
+	}
+}
+
+// updateMatchmakingRatings adjusts each player's per-variant rating based on
+// how the game concluded; it is called from Game.End right after the game
+// result has been announced in the lobby
+func updateMatchmakingRatings(g *Game) {
+	if g.EndCondition == EndConditionAbandoned {
+		// An abandoned game was never actually finished by the table, so it
+		// should not move anyone's rating in either direction
+		return
+	}
+
+	for _, p := range g.Players {
+		delta := 0
+		switch {
+		case g.Score == g.MaxScore():
+			delta = 2
+		case g.EndCondition > 1:
+			delta = -1
+		default:
+			// A partial, non-strikeout score is treated as a wash
+			delta = 0
+		}
+
+		adjustRating(p.ID, g.Options.Variant, delta)
+
+		if delta == 0 {
+			continue
+		}
+
+		if err := db.UserStats.UpdateRating(p.ID, g.Options.Variant, delta); err != nil {
+			log.Error("Failed to update the matchmaking rating for player "+strconv.Itoa(p.ID)+":", err)
+		}
+	}
+}
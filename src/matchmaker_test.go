@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTargetGroupSize(t *testing.T) {
+	cases := []struct {
+		name        string
+		playerCount int
+		available   int
+		want        int
+	}{
+		{"no preference, plenty waiting", 0, 8, matchmakingMaxPlayers},
+		{"no preference, pool smaller than cap", 0, 3, 3},
+		{"preference within range", 4, 8, 4},
+		{"preference above the cap", 8, 8, matchmakingMaxPlayers},
+		{"preference below the minimum", 1, 8, matchmakingMinPlayers},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key := matchmakingKey{PlayerCount: c.playerCount}
+			if got := targetGroupSize(key, c.available); got != c.want {
+				t.Errorf("targetGroupSize(%+v, %d) = %d, want %d", key, c.available, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRatingBiasedOrderKeepsLongestWaitingFirst(t *testing.T) {
+	const variant = 0
+	adjustRating(1, variant, 0) // Anchor stays at the neutral rating
+	adjustRating(2, variant, 10)
+	adjustRating(3, variant, -10)
+	adjustRating(4, variant, 1) // Closest to the anchor
+
+	now := time.Unix(1700000000, 0)
+	sessions := []*queuedSession{
+		{UserID: 1, EnqueuedAt: now},                      // Longest waiting; must stay first
+		{UserID: 2, EnqueuedAt: now.Add(1 * time.Second)}, // Rating far from the anchor
+		{UserID: 3, EnqueuedAt: now.Add(2 * time.Second)}, // Rating far from the anchor
+		{UserID: 4, EnqueuedAt: now.Add(3 * time.Second)}, // Rating closest to the anchor
+	}
+
+	ordered := ratingBiasedOrder(sessions, variant)
+
+	if ordered[0].UserID != 1 {
+		t.Fatalf("expected the longest-waiting session first, got UserID=%d", ordered[0].UserID)
+	}
+	if ordered[1].UserID != 4 {
+		t.Fatalf("expected the closest-rated session second, got UserID=%d", ordered[1].UserID)
+	}
+}
+
+func TestGetRatingDefaultsToZero(t *testing.T) {
+	if got := getRating(999999, 0); got != 0 {
+		t.Fatalf("expected an unrated player to default to 0, got %d", got)
+	}
+
+	adjustRating(999999, 0, 2)
+	adjustRating(999999, 0, -1)
+	if got := getRating(999999, 0); got != 1 {
+		t.Fatalf("expected rating adjustments to accumulate, got %d", got)
+	}
+
+	// A rating for a different variant must not be affected
+	if got := getRating(999999, 1); got != 0 {
+		t.Fatalf("expected an unrelated variant to stay at 0, got %d", got)
+	}
+}
+
+func TestRemainingSessionsPreservesFIFOOrder(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	sessions := []*queuedSession{
+		{UserID: 1, EnqueuedAt: now},
+		{UserID: 2, EnqueuedAt: now.Add(time.Second)},
+		{UserID: 3, EnqueuedAt: now.Add(2 * time.Second)},
+	}
+	group := []*queuedSession{sessions[1]}
+
+	rest := remainingSessions(sessions, group)
+
+	if len(rest) != 2 || rest[0].UserID != 1 || rest[1].UserID != 3 {
+		t.Fatalf("expected [1, 3] to remain in order, got %+v", rest)
+	}
+}
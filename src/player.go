@@ -0,0 +1,22 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Zamiell/hanabi-live/src/models"
+)
+
+// Player represents one seat at the table. "Session" is a GameSession rather
+// than a concrete *Session so that a Bot can occupy a seat the same way a
+// human does; every "p.Session.Emit(...)" / "p.Session.NotifyGameAction(...)"
+// style call elsewhere in this package works unchanged for either.
+type Player struct {
+	ID      int
+	Name    string
+	Session GameSession
+	Present bool
+	Time    time.Duration
+	Hand    []*Card
+	Notes   []string
+	Stats   models.Stats
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Zamiell/hanabi-live/src/gamelog"
+)
+
+// RecoverGames is called once at server startup, after the database
+// connection is established but before the lobby starts accepting
+// connections. It scans the write-ahead log directory for games that never
+// made it into the "games" (finished-game) database table and reconstructs
+// them in memory, so that a server crash does not silently drop whatever
+// games were in progress.
+func RecoverGames() {
+	if !gamelog.Enabled() {
+		return
+	}
+
+	ids, err := gamelog.List()
+	if err != nil {
+		log.Error("Failed to list the write-ahead log directory:", err)
+		return
+	}
+
+	for _, id := range ids {
+		if finished, err := db.Games.Exists(id); err != nil {
+			log.Error("Failed to check whether game "+strconv.Itoa(id)+" was finished:", err)
+			continue
+		} else if finished {
+			// The game finished normally and its WAL file is just waiting to be pruned
+			continue
+		}
+
+		if err := recoverGame(id); err != nil {
+			log.Error("Failed to recover game "+strconv.Itoa(id)+" from the write-ahead log:", err)
+		}
+	}
+}
+
+// recoverGame rebuilds a game's score, turn, and action log from its WAL
+// file. It does NOT reconstruct hands or the shuffled/discarded state of the
+// deck (gamelog.Entry never captured them), so the result is only good for
+// inspection and for finishing the database bookkeeping the crash
+// interrupted — it cannot actually be resumed as a live, playable table. The
+// game is marked "Recovered" and its timer is never started so that nothing
+// tries to treat it as one.
+func recoverGame(id int) error {
+	entries, err := gamelog.Read(id)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	first := entries[0]
+	last := entries[len(entries)-1]
+	options := &Options{}
+	if len(first.Options) > 0 {
+		if err := json.Unmarshal(first.Options, options); err != nil {
+			return err
+		}
+	}
+
+	g := &Game{
+		ID:               id,
+		Name:             "Game #" + strconv.Itoa(id),
+		Seed:             first.Seed,
+		Options:          options,
+		Running:          true,
+		Recovered:        true,
+		DatetimeCreated:  first.DatetimeCreated,
+		DatetimeStarted:  first.DatetimeCreated,
+		Deck:             NewDeck(first.Seed),
+		DiscardSignal:    &DiscardSignal{},
+		Spectators:       make(map[int]*Session),
+		DisconSpectators: make(map[int]bool),
+	}
+
+	// Re-seat the players who were present when the crash happened, using
+	// their most recently logged clock and notes; they will land back in the
+	// same seat when they reconnect, since "g.Players" is keyed by index and
+	// "p.ID" is preserved. "Session" stays nil until they do.
+	for _, pi := range last.Players {
+		g.Players = append(g.Players, &Player{
+			ID:      pi.ID,
+			Name:    pi.Name,
+			Present: false,
+			Time:    pi.Time,
+			Notes:   append([]string(nil), pi.Notes...),
+		})
+	}
+
+	for _, entry := range entries {
+		g.Turn = entry.Turn
+		g.ActivePlayer = entry.ActivePlayer
+		g.TurnBeginTime = entry.DatetimeCreated
+
+		// Restore the snapshot of derived state that was taken right after this
+		// action was originally applied, instead of re-deriving it by running the
+		// action back through the normal command handlers (those depend on a
+		// fully-seeded deck and validation state that a bare Action does not
+		// carry); this appends directly to "g.Actions" instead of going through
+		// "g.AppendAction", since the entry is already durably on disk
+		if err := applyLoggedAction(g, entry); err != nil {
+			return err
+		}
+	}
+
+	games[g.ID] = g
+	log.Info(g.GetName() + "Recovered from the write-ahead log with " + strconv.Itoa(len(entries)) +
+		" actions. Hands and deck state were not preserved, so this game cannot be resumed; " +
+		"an administrator needs to resolve it manually.")
+
+	return nil
+}
+
+// applyLoggedAction restores the snapshot of derived game state carried by
+// entry (score, clues, strikes, stacks, deck position) and appends the
+// action itself to "g.Actions" so that the replay ends up with an action log
+// identical to the one the game had right before it crashed
+func applyLoggedAction(g *Game, entry gamelog.Entry) error {
+	var a Action
+	if err := json.Unmarshal(entry.Action, &a); err != nil {
+		return err
+	}
+
+	g.Score = entry.Score
+	g.Strikes = entry.Strikes
+	g.Clues = entry.Clues
+	g.DeckIndex = entry.DeckIndex
+	g.EndTurn = entry.EndTurn
+	g.Stacks = append([]int(nil), entry.Stacks...)
+	g.Actions = append(g.Actions, a)
+
+	return nil
+}
+
+// StartGamelogPruner runs forever in its own goroutine, periodically deleting
+// WAL files that are older than maxAge. A file that old was either already
+// recovered (and its game has since finished, but something went wrong with
+// the normal post-game deletion) or its game was abandoned before finishing,
+// so it is safe to discard.
+func StartGamelogPruner(maxAge time.Duration) {
+	if !gamelog.Enabled() {
+		return
+	}
+
+	for {
+		time.Sleep(time.Hour)
+
+		if pruned, err := gamelog.Prune(maxAge); err != nil {
+			log.Error("Failed to prune the write-ahead log directory:", err)
+		} else if pruned > 0 {
+			log.Info("Pruned " + strconv.Itoa(pruned) + " orphaned write-ahead log file(s).")
+		}
+	}
+}